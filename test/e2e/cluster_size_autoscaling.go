@@ -17,13 +17,18 @@ limitations under the License.
 package e2e
 
 import (
+	"encoding/json"
 	"fmt"
-	"strings"
 	"time"
 
 	"k8s.io/kubernetes/pkg/api"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/unversioned"
 	client "k8s.io/kubernetes/pkg/client/unversioned"
 	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/types"
+	"k8s.io/kubernetes/pkg/util/intstr"
 	"k8s.io/kubernetes/test/e2e/framework"
 
 	"github.com/golang/glog"
@@ -44,9 +49,14 @@ var _ = framework.KubeDescribe("Cluster size autoscaling [Slow]", func() {
 	var coresPerNode int
 	var memCapacityMb int
 	var originalSizes map[string]int
+	var nodeGroups NodeGroupManager
 
 	BeforeEach(func() {
-		framework.SkipUnlessProviderIs("gce")
+		var err error
+		nodeGroups, err = NewNodeGroupManager()
+		if err != nil {
+			framework.Skipf("%v", err)
+		}
 
 		nodes := framework.GetReadySchedulableNodesOrDie(f.Client)
 		nodeCount = len(nodes.Items)
@@ -58,11 +68,11 @@ var _ = framework.KubeDescribe("Cluster size autoscaling [Slow]", func() {
 
 		originalSizes = make(map[string]int)
 		sum := 0
-		for _, mig := range strings.Split(framework.TestContext.CloudConfig.NodeInstanceGroup, ",") {
-			size, err := GroupSize(mig)
+		for _, group := range nodeGroups.List() {
+			size, err := nodeGroups.Size(group)
 			framework.ExpectNoError(err)
-			By(fmt.Sprintf("Initial size of %s: %d", mig, size))
-			originalSizes[mig] = size
+			By(fmt.Sprintf("Initial size of %s: %d", group, size))
+			originalSizes[group] = size
 			sum += size
 		}
 		Expect(nodeCount).Should(Equal(sum))
@@ -70,9 +80,22 @@ var _ = framework.KubeDescribe("Cluster size autoscaling [Slow]", func() {
 
 	It("shouldn't increase cluster size if pending pod it too large [Feature:ClusterSizeAutoscalingScaleUp]", func() {
 		ReserveMemory(f, "memory-reservation", 1, memCapacityMb, false)
+
+		By("Waiting for a NotTriggerScaleUp event on the pending pod")
+		pods, err := f.Client.Pods(f.Namespace.Name).List(api.ListOptions{
+			LabelSelector: labels.SelectorFromSet(labels.Set{"name": "memory-reservation"}),
+		})
+		framework.ExpectNoError(err)
+		Expect(pods.Items).To(HaveLen(1))
+		_, err = WaitForAutoscalerDecision(f.Client, isEventForPod(&pods.Items[0], notTriggerScaleUpReason), scaleUpTimeout)
+		framework.ExpectNoError(err)
+
+		By("Cross-checking the autoscaler status ConfigMap agrees no scale-up was triggered")
+		status, err := ClusterAutoscalerStatus(f.Client)
+		framework.ExpectNoError(err)
+		Expect(status).NotTo(ContainSubstring(triggeredScaleUpReason))
+
 		// Verify, that cluster size is not changed.
-		// TODO: find a better way of verification that the cluster size will remain unchanged using events.
-		time.Sleep(scaleUpTimeout)
 		framework.ExpectNoError(WaitForClusterSizeFunc(f.Client,
 			func(size int) bool { return size <= nodeCount }, scaleDownTimeout))
 		framework.ExpectNoError(framework.DeleteRC(f.Client, f.Namespace.Name, "memory-reservation"))
@@ -86,7 +109,7 @@ var _ = framework.KubeDescribe("Cluster size autoscaling [Slow]", func() {
 		framework.ExpectNoError(WaitForClusterSizeFunc(f.Client,
 			func(size int) bool { return size >= nodeCount+1 }, scaleUpTimeout))
 		framework.ExpectNoError(framework.DeleteRC(f.Client, f.Namespace.Name, "memory-reservation"))
-		restoreSizes(originalSizes)
+		restoreSizes(nodeGroups, originalSizes)
 		framework.ExpectNoError(WaitForClusterSizeFunc(f.Client,
 			func(size int) bool { return size <= nodeCount }, scaleDownTimeout))
 	})
@@ -96,7 +119,7 @@ var _ = framework.KubeDescribe("Cluster size autoscaling [Slow]", func() {
 		framework.ExpectNoError(WaitForClusterSizeFunc(f.Client,
 			func(size int) bool { return size >= nodeCount+2 }, scaleUpTimeout))
 		framework.ExpectNoError(framework.DeleteRC(f.Client, f.Namespace.Name, "host-port"))
-		restoreSizes(originalSizes)
+		restoreSizes(nodeGroups, originalSizes)
 		framework.ExpectNoError(WaitForClusterSizeFunc(f.Client,
 			func(size int) bool { return size <= nodeCount }, scaleDownTimeout))
 
@@ -112,6 +135,92 @@ var _ = framework.KubeDescribe("Cluster size autoscaling [Slow]", func() {
 		framework.ExpectNoError(WaitForClusterSizeFunc(f.Client,
 			func(size int) bool { return size < nodeCount+1 }, scaleDownTimeout))
 	})
+
+	It("should remove an empty node shortly after its pods are deleted [Feature:ClusterSizeAutoscalingScaleDown]", func() {
+		By("Scaling up so that one node will end up empty")
+		ReserveMemory(f, "memory-reservation", 100, nodeCount*memCapacityMb, false)
+		framework.ExpectNoError(WaitForClusterSizeFunc(f.Client,
+			func(size int) bool { return size >= nodeCount+1 }, scaleUpTimeout))
+
+		By("Deleting the reservation so a node becomes empty")
+		target := emptiedNodeName(f, "memory-reservation")
+		framework.ExpectNoError(framework.DeleteRC(f.Client, f.Namespace.Name, "memory-reservation"))
+
+		By(fmt.Sprintf("Waiting for node %s to be removed", target))
+		framework.ExpectNoError(WaitForNodeToDisappear(f.Client, target, scaleDownTimeout))
+		framework.ExpectNoError(WaitForClusterSizeFunc(f.Client,
+			func(size int) bool { return size <= nodeCount }, scaleDownTimeout))
+	})
+
+	It("should drain and remove an underutilized node while pods reschedule with zero downtime [Feature:ClusterSizeAutoscalingScaleDown]", func() {
+		replicas := nodeCount * 2
+		By("Running a replicated service that spreads across every node")
+		ReserveMemoryWithService(f, "underutilized-svc", replicas, nodeCount*memCapacityMb/2, true)
+		framework.ExpectNoError(WaitForClusterSizeFunc(f.Client,
+			func(size int) bool { return size >= nodeCount }, scaleUpTimeout))
+
+		By("Scaling the RC down so most nodes are underutilized")
+		framework.ExpectNoError(framework.ScaleRC(f.Client, f.Namespace.Name, "underutilized-svc", uint(nodeCount/2+1), true))
+
+		By("Waiting for the cluster to scale down while the service keeps serving")
+		framework.ExpectNoError(WaitForServiceEndpointsNum(f.Client, f.Namespace.Name, "underutilized-svc", nodeCount/2+1, scaleDownTimeout))
+		framework.ExpectNoError(WaitForClusterSizeFunc(f.Client,
+			func(size int) bool {
+				endpoints, err := f.Client.Endpoints(f.Namespace.Name).Get("underutilized-svc")
+				if err == nil {
+					Expect(countEndpointsNum(endpoints)).NotTo(BeZero(), "service underutilized-svc had no endpoints during scale-down")
+				}
+				return size < nodeCount
+			}, scaleDownTimeout))
+		framework.ExpectNoError(framework.DeleteRC(f.Client, f.Namespace.Name, "underutilized-svc"))
+	})
+
+	It("should drain a cordoned, tainted node's pods elsewhere and remove it once empty [Feature:ClusterSizeAutoscalingScaleDown]", func() {
+		By("Running a replicated service spread across the cluster")
+		replicas := nodeCount * 2
+		ReserveMemoryWithService(f, "taint-drain-svc", replicas, nodeCount*memCapacityMb/2, true)
+		framework.ExpectNoError(WaitForServiceEndpointsNum(f.Client, f.Namespace.Name, "taint-drain-svc", replicas, defaultTimeout))
+
+		target := emptiedNodeName(f, "taint-drain-svc")
+
+		By(fmt.Sprintf("Cordoning and tainting node %s so it can no longer receive pods", target))
+		framework.ExpectNoError(cordonNode(f.Client, target))
+		framework.ExpectNoError(taintNode(f.Client, target, nodeTaint{Key: "dedicated", Value: "autoscaling-e2e", Effect: "NoSchedule"}))
+
+		By(fmt.Sprintf("Deleting the pods still pinned to %s so they reschedule onto the rest of the cluster", target))
+		framework.ExpectNoError(deletePodsOnNode(f.Client, f.Namespace.Name, target))
+		framework.ExpectNoError(WaitForServiceEndpointsNum(f.Client, f.Namespace.Name, "taint-drain-svc", replicas, defaultTimeout))
+
+		By(fmt.Sprintf("Waiting for the now-empty, tainted node %s to be removed", target))
+		framework.ExpectNoError(WaitForNodeToDisappear(f.Client, target, scaleDownTimeout))
+
+		framework.ExpectNoError(framework.DeleteRC(f.Client, f.Namespace.Name, "taint-drain-svc"))
+	})
+
+	It("should balance scale-up across node groups [Feature:ClusterSizeAutoscalingScaleUp]", func() {
+		groups := nodeGroups.List()
+		if len(groups) < 2 {
+			framework.Skipf("need at least 2 node groups to test balancing, got %d", len(groups))
+		}
+
+		startSizes, err := GroupSizes(nodeGroups, groups)
+		framework.ExpectNoError(err)
+		By(fmt.Sprintf("Starting group sizes: %v", startSizes))
+
+		By("Scheduling a batch of small, mutually anti-affine pods")
+		replicas := nodeCount + len(groups)*2
+		ReserveMemoryWithAntiAffinity(f, "balanced-scaleup", replicas, replicas*64)
+		framework.ExpectNoError(WaitForClusterSizeFunc(f.Client,
+			func(size int) bool { return size >= replicas }, scaleUpTimeout))
+
+		By("Waiting for the new nodes to be balanced across node groups")
+		framework.ExpectNoError(WaitForBalancedGroups(nodeGroups, groups, startSizes, 1, scaleUpTimeout))
+
+		framework.ExpectNoError(framework.DeleteRC(f.Client, f.Namespace.Name, "balanced-scaleup"))
+		restoreSizes(nodeGroups, originalSizes)
+		framework.ExpectNoError(WaitForClusterSizeFunc(f.Client,
+			func(size int) bool { return size <= nodeCount }, scaleDownTimeout))
+	})
 })
 
 func CreateHostPortPods(f *framework.Framework, id string, replicas int, expectRunning bool) {
@@ -192,14 +301,298 @@ func WaitForClusterSizeFunc(c *client.Client, sizeFunc func(int) bool, timeout t
 	return fmt.Errorf("timeout waiting %v for appropriate cluster size", timeout)
 }
 
-func restoreSizes(sizes map[string]int) {
+const (
+	triggeredScaleUpReason  = "TriggeredScaleUp"
+	notTriggerScaleUpReason = "NotTriggerScaleUp"
+	scaleDownReason         = "ScaleDown"
+
+	clusterAutoscalerStatusConfigMapName = "cluster-autoscaler-status"
+)
+
+// WaitForAutoscalerDecision polls the Events API for the autoscaler decision
+// events (TriggeredScaleUp, NotTriggerScaleUp, ScaleDown) it posts to pods
+// and nodes, returning the first one matching predicate. This replaces
+// sleeping for a fixed timeout with a positive confirmation of *why* the
+// autoscaler did or didn't act.
+func WaitForAutoscalerDecision(c *client.Client, predicate func(*api.Event) bool, timeout time.Duration) (*api.Event, error) {
+	seen := make(map[types.UID]bool)
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(10 * time.Second) {
+		events, err := c.Events(api.NamespaceAll).List(api.ListOptions{})
+		if err != nil {
+			glog.Warningf("Failed to list events: %v", err)
+			continue
+		}
+		for i := range events.Items {
+			event := &events.Items[i]
+			if seen[event.UID] {
+				continue
+			}
+			seen[event.UID] = true
+			if event.Reason != triggeredScaleUpReason && event.Reason != notTriggerScaleUpReason && event.Reason != scaleDownReason {
+				continue
+			}
+			if predicate(event) {
+				return event, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("timeout waiting %v for a matching autoscaler decision event", timeout)
+}
+
+// isEventForPod returns a WaitForAutoscalerDecision predicate matching
+// events with the given reason raised against pod.
+func isEventForPod(pod *api.Pod, reason string) func(*api.Event) bool {
+	return func(event *api.Event) bool {
+		return event.Reason == reason &&
+			event.InvolvedObject.Kind == "Pod" &&
+			event.InvolvedObject.Namespace == pod.Namespace &&
+			event.InvolvedObject.Name == pod.Name
+	}
+}
+
+// ClusterAutoscalerStatus returns the raw status cluster-autoscaler
+// publishes to its kube-system ConfigMap, for assertions that want the
+// autoscaler's own view of cluster health rather than individual events.
+func ClusterAutoscalerStatus(c *client.Client) (string, error) {
+	cm, err := c.ConfigMaps("kube-system").Get(clusterAutoscalerStatusConfigMapName)
+	if err != nil {
+		return "", err
+	}
+	return cm.Data["status"], nil
+}
+
+// ReserveMemoryWithService works like ReserveMemory, but also creates a
+// Service in front of the reservation RC so that tests can verify pods keep
+// serving while nodes backing them are drained.
+func ReserveMemoryWithService(f *framework.Framework, id string, replicas, megabytes int, expectRunning bool) {
+	ReserveMemory(f, id, replicas, megabytes, expectRunning)
+	By(fmt.Sprintf("Running a service in front of %s", id))
+	service := &api.Service{
+		ObjectMeta: api.ObjectMeta{
+			Name:      id,
+			Namespace: f.Namespace.Name,
+		},
+		Spec: api.ServiceSpec{
+			Selector: map[string]string{"name": id},
+			Ports: []api.ServicePort{
+				{Port: 80, TargetPort: intstr.FromInt(80)},
+			},
+		},
+	}
+	_, err := f.Client.Services(f.Namespace.Name).Create(service)
+	framework.ExpectNoError(err)
+}
+
+// emptiedNodeName returns the name of the node hosting the most pods of the
+// named RC, i.e. the node expected to become empty once the RC is deleted.
+func emptiedNodeName(f *framework.Framework, rcName string) string {
+	pods, err := f.Client.Pods(f.Namespace.Name).List(api.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{"name": rcName}),
+	})
+	framework.ExpectNoError(err)
+	Expect(len(pods.Items)).NotTo(BeZero())
+
+	counts := make(map[string]int)
+	for _, pod := range pods.Items {
+		counts[pod.Spec.NodeName]++
+	}
+	target, best := "", -1
+	for node, count := range counts {
+		if count > best {
+			target, best = node, count
+		}
+	}
+	return target
+}
+
+// ReserveMemoryWithAntiAffinity runs replicas small pods that reserve
+// megabytes of memory in total, each mutually exclusive with the others on a
+// single node, so the scheduler is forced to spread them across nodes.
+func ReserveMemoryWithAntiAffinity(f *framework.Framework, id string, replicas, megabytes int) {
+	By(fmt.Sprintf("Running %d mutually anti-affine pods reserving %v MB total", replicas, megabytes))
+	request := int64(1024 * 1024 * megabytes / replicas)
+	affinity := api.Affinity{
+		PodAntiAffinity: &api.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []api.PodAffinityTerm{
+				{
+					LabelSelector: &unversioned.LabelSelector{MatchLabels: map[string]string{"name": id}},
+					TopologyKey:   "kubernetes.io/hostname",
+				},
+			},
+		},
+	}
+	encodedAffinity, err := json.Marshal(affinity)
+	framework.ExpectNoError(err)
+	config := &framework.RCConfig{
+		Client:     f.Client,
+		Name:       id,
+		Namespace:  f.Namespace.Name,
+		Timeout:    defaultTimeout,
+		Image:      framework.GetPauseImageName(f.Client),
+		Replicas:   replicas,
+		MemRequest: request,
+		Annotations: map[string]string{
+			api.AffinityAnnotationKey: string(encodedAffinity),
+		},
+	}
+	framework.ExpectNoError(framework.RunRC(*config))
+}
+
+// GroupSizes returns the current size of each named node group.
+func GroupSizes(nodeGroups NodeGroupManager, groups []string) (map[string]int, error) {
+	sizes := make(map[string]int)
+	for _, group := range groups {
+		size, err := nodeGroups.Size(group)
+		if err != nil {
+			return nil, err
+		}
+		sizes[group] = size
+	}
+	return sizes, nil
+}
+
+// WaitForBalancedGroups waits until the per-group growth since startSizes —
+// i.e. current[group]-startSizes[group], not the groups' raw current sizes —
+// is within tolerance nodes across every group. Comparing deltas rather than
+// absolute sizes keeps the check valid even when groups didn't start at the
+// same size.
+func WaitForBalancedGroups(nodeGroups NodeGroupManager, groups []string, startSizes map[string]int, tolerance int, timeout time.Duration) error {
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(20 * time.Second) {
+		sizes, err := GroupSizes(nodeGroups, groups)
+		if err != nil {
+			glog.Warningf("Failed to get node group sizes: %v", err)
+			continue
+		}
+		deltas := make(map[string]int, len(sizes))
+		min, max := -1, -1
+		for group, size := range sizes {
+			delta := size - startSizes[group]
+			deltas[group] = delta
+			if min == -1 || delta < min {
+				min = delta
+			}
+			if max == -1 || delta > max {
+				max = delta
+			}
+		}
+		if max-min <= tolerance {
+			return nil
+		}
+		glog.Infof("Waiting for balanced node groups, current size deltas: %v", deltas)
+	}
+	return fmt.Errorf("timeout waiting %v for node groups %v to balance growth within %d nodes", timeout, groups, tolerance)
+}
+
+// WaitForNodeToDisappear waits for the named node to no longer be listed.
+func WaitForNodeToDisappear(c *client.Client, name string, timeout time.Duration) error {
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(20 * time.Second) {
+		_, err := c.Nodes().Get(name)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			glog.Warningf("Failed to get node %s: %v", name, err)
+		}
+	}
+	return fmt.Errorf("timeout waiting %v for node %s to disappear", timeout, name)
+}
+
+// WaitForServiceEndpointsNum waits until the named service has exactly
+// expectNum ready endpoints.
+func WaitForServiceEndpointsNum(c *client.Client, ns, serviceName string, expectNum int, timeout time.Duration) error {
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(5 * time.Second) {
+		endpoints, err := c.Endpoints(ns).Get(serviceName)
+		if err != nil {
+			glog.Warningf("Failed to get endpoints for %s: %v", serviceName, err)
+			continue
+		}
+		if countEndpointsNum(endpoints) == expectNum {
+			return nil
+		}
+	}
+	return fmt.Errorf("timeout waiting %v for %d endpoints of service %s", timeout, expectNum, serviceName)
+}
+
+func countEndpointsNum(e *api.Endpoints) int {
+	num := 0
+	for _, subset := range e.Subsets {
+		num += len(subset.Addresses)
+	}
+	return num
+}
+
+// deletePodsOnNode deletes every pod in ns scheduled onto nodeName, letting
+// their controller recreate and reschedule them elsewhere.
+func deletePodsOnNode(c *client.Client, ns, nodeName string) error {
+	pods, err := c.Pods(ns).List(api.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != nodeName {
+			continue
+		}
+		if err := c.Pods(ns).Delete(pod.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cordonNode marks the named node as unschedulable.
+func cordonNode(c *client.Client, name string) error {
+	node, err := c.Nodes().Get(name)
+	if err != nil {
+		return err
+	}
+	node.Spec.Unschedulable = true
+	_, err = c.Nodes().Update(node)
+	return err
+}
+
+// nodeTaint mirrors the alpha scheduler.alpha.kubernetes.io/taints node
+// annotation format used before taints became a first-class API field.
+type nodeTaint struct {
+	Key    string `json:"key,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect,omitempty"`
+}
+
+const taintsAnnotationKey = "scheduler.alpha.kubernetes.io/taints"
+
+// taintNode adds taint to the named node's taints annotation.
+func taintNode(c *client.Client, name string, taint nodeTaint) error {
+	node, err := c.Nodes().Get(name)
+	if err != nil {
+		return err
+	}
+	var taints []nodeTaint
+	if existing, ok := node.Annotations[taintsAnnotationKey]; ok {
+		if err := json.Unmarshal([]byte(existing), &taints); err != nil {
+			return err
+		}
+	}
+	taints = append(taints, taint)
+	encoded, err := json.Marshal(taints)
+	if err != nil {
+		return err
+	}
+	if node.Annotations == nil {
+		node.Annotations = make(map[string]string)
+	}
+	node.Annotations[taintsAnnotationKey] = string(encoded)
+	_, err = c.Nodes().Update(node)
+	return err
+}
+
+func restoreSizes(nodeGroups NodeGroupManager, sizes map[string]int) {
 	By(fmt.Sprintf("Restoring initial size of the cluster"))
-	for mig, desiredSize := range sizes {
-		currentSize, err := GroupSize(mig)
+	for group, desiredSize := range sizes {
+		currentSize, err := nodeGroups.Size(group)
 		framework.ExpectNoError(err)
 		if desiredSize != currentSize {
-			By(fmt.Sprintf("Setting size of %s to %d", mig, desiredSize))
-			err = ResizeGroup(mig, int32(desiredSize))
+			By(fmt.Sprintf("Setting size of %s to %d", group, desiredSize))
+			err = nodeGroups.Resize(group, int32(desiredSize))
 			framework.ExpectNoError(err)
 		}
 	}